@@ -0,0 +1,108 @@
+// Package accountindex maps a gpg cache ID to the unpredictable Keychain
+// account name used to store its PIN.
+//
+// We deliberately do not use the human-readable "Name <email> (keyID)"
+// string as the Keychain account, because any other application on the
+// system can query the Keychain for that same predictable string and read
+// the PIN (see keybase/client#484). Instead each cache ID is mapped to 32
+// random bytes generated on first use; the mapping itself contains no
+// secret material, so it is kept in a plain file rather than the Keychain.
+package accountindex
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// accountPrefix makes it easy to spot our own Keychain items (e.g. when
+// auditing the Keychain app) without making the account name guessable.
+const accountPrefix = "pinentry-touchid-"
+
+// Index is an on-disk, non-secret mapping from gpg cache ID to Keychain
+// account name.
+type Index struct {
+	path    string
+	entries map[string]string
+}
+
+// defaultPath returns ~/.config/pinentry-touchid/accounts.json (or the
+// platform equivalent), creating its parent directory if necessary.
+func defaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "pinentry-touchid")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "accounts.json"), nil
+}
+
+// Open loads the index from its default location, returning an empty one if
+// it does not yet exist.
+func Open() (*Index, error) {
+	path, err := defaultPath()
+	if err != nil {
+		return nil, err
+	}
+	return OpenFile(path)
+}
+
+// OpenFile loads the index from path, returning an empty one if it does not
+// yet exist. The file itself is not written until Account adds an entry.
+func OpenFile(path string) (*Index, error) {
+	idx := &Index{path: path, entries: map[string]string{}}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &idx.entries); err != nil {
+		return nil, fmt.Errorf("accountindex: parsing %s: %w", path, err)
+	}
+	return idx, nil
+}
+
+// Account returns the Keychain account name for cacheID, generating and
+// persisting a new random one if cacheID has not been seen before.
+func (idx *Index) Account(cacheID string) (string, error) {
+	if account, ok := idx.entries[cacheID]; ok {
+		return account, nil
+	}
+
+	account, err := newAccount()
+	if err != nil {
+		return "", err
+	}
+
+	idx.entries[cacheID] = account
+	if err := idx.save(); err != nil {
+		return "", err
+	}
+	return account, nil
+}
+
+func (idx *Index) save() error {
+	data, err := json.MarshalIndent(idx.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(idx.path, data, 0600)
+}
+
+func newAccount() (string, error) {
+	var buf [32]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", fmt.Errorf("accountindex: generating random account: %w", err)
+	}
+	return accountPrefix + hex.EncodeToString(buf[:]), nil
+}
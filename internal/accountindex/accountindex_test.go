@@ -0,0 +1,137 @@
+package accountindex
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAccountGeneratesAndPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+
+	idx, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+
+	account, err := idx.Account("cache-id-1")
+	if err != nil {
+		t.Fatalf("Account: %v", err)
+	}
+	if !strings.HasPrefix(account, accountPrefix) {
+		t.Errorf("account %q does not have prefix %q", account, accountPrefix)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading persisted index: %v", err)
+	}
+	var entries map[string]string
+	if err := json.Unmarshal(data, &entries); err != nil {
+		t.Fatalf("unmarshalling persisted index: %v", err)
+	}
+	if entries["cache-id-1"] != account {
+		t.Errorf("persisted entry = %q, want %q", entries["cache-id-1"], account)
+	}
+}
+
+func TestAccountIsStableForSameCacheID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+
+	idx, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+
+	first, err := idx.Account("cache-id-1")
+	if err != nil {
+		t.Fatalf("Account: %v", err)
+	}
+	second, err := idx.Account("cache-id-1")
+	if err != nil {
+		t.Fatalf("Account: %v", err)
+	}
+	if first != second {
+		t.Errorf("Account returned %q then %q for the same cache ID", first, second)
+	}
+}
+
+func TestAccountIsUniquePerCacheID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+
+	idx, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+
+	a, err := idx.Account("cache-id-1")
+	if err != nil {
+		t.Fatalf("Account: %v", err)
+	}
+	b, err := idx.Account("cache-id-2")
+	if err != nil {
+		t.Fatalf("Account: %v", err)
+	}
+	if a == b {
+		t.Errorf("distinct cache IDs got the same account %q", a)
+	}
+}
+
+func TestOpenFileLoadsExistingEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+
+	idx, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	account, err := idx.Account("cache-id-1")
+	if err != nil {
+		t.Fatalf("Account: %v", err)
+	}
+
+	reopened, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile (reopen): %v", err)
+	}
+	got, err := reopened.Account("cache-id-1")
+	if err != nil {
+		t.Fatalf("Account (reopen): %v", err)
+	}
+	if got != account {
+		t.Errorf("reopened index returned %q, want the previously persisted %q", got, account)
+	}
+}
+
+func TestOpenFileMissingFileIsNotAnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "does-not-exist.json")
+
+	idx, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if len(idx.entries) != 0 {
+		t.Errorf("expected an empty index, got %d entries", len(idx.entries))
+	}
+}
+
+func TestAccountFilePermissions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+
+	idx, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if _, err := idx.Account("cache-id-1"); err != nil {
+		t.Fatalf("Account: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("accounts.json permissions = %o, want 0600", perm)
+	}
+}
@@ -0,0 +1,30 @@
+package assuan
+
+import "fmt"
+
+// Error is an Assuan protocol error, reported to the client as
+// "ERR <code> <message>".
+type Error struct {
+	Code    uint64
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("assuan: %s (%d)", e.Message, e.Code)
+}
+
+// Common errors a pinentry returns to gpg-agent. The codes match what
+// GnuPG's own pinentry sends, so gpg-agent (and, in turn, gpg) can tell a
+// user cancellation or a declined confirmation apart from a protocol
+// failure, rather than treating every non-OK reply as a hard error.
+var (
+	// ErrCancelled means the user dismissed the prompt (e.g. denied Touch ID
+	// or clicked Cancel) rather than supplying a PIN.
+	ErrCancelled = &Error{Code: 83886179, Message: "Operation cancelled"}
+	// ErrNotConfirmed means a CONFIRM request was answered "no".
+	ErrNotConfirmed = &Error{Code: 83886194, Message: "Not confirmed"}
+	// ErrGeneral is returned for unexpected failures (e.g. a Keychain I/O
+	// error) that prevent a GETPIN request from completing, but that should
+	// not bring down the whole pinentry process.
+	ErrGeneral = &Error{Code: 83886081, Message: "General error"}
+)
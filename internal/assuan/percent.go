@@ -0,0 +1,39 @@
+package assuan
+
+import (
+	"fmt"
+	"strings"
+)
+
+// percentEncode escapes s for use in a line of the Assuan protocol, where
+// '%', CR, LF and NUL must not appear literally.
+func percentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '%', '\r', '\n', 0:
+			fmt.Fprintf(&b, "%%%02X", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// percentDecode reverses percentEncode, decoding "%XX" escapes in place.
+func percentDecode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			var v int
+			if _, err := fmt.Sscanf(s[i+1:i+3], "%02X", &v); err == nil {
+				b.WriteByte(byte(v))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,62 @@
+package assuan
+
+import "testing"
+
+func TestPercentEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty", "", ""},
+		{"no special characters", "hello world", "hello world"},
+		{"percent", "50%", "50%25"},
+		{"carriage return", "a\rb", "a%0Db"},
+		{"line feed", "a\nb", "a%0Ab"},
+		{"nul", "a\x00b", "a%00b"},
+		{"multiple escapes", "100%\r\n", "100%25%0D%0A"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentEncode(tt.in); got != tt.want {
+				t.Errorf("percentEncode(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPercentDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty", "", ""},
+		{"no escapes", "hello world", "hello world"},
+		{"percent", "50%25", "50%"},
+		{"carriage return", "a%0Db", "a\rb"},
+		{"line feed", "a%0Ab", "a\nb"},
+		{"nul", "a%00b", "a\x00b"},
+		{"lowercase hex", "a%0ab", "a\nb"},
+		{"trailing percent with no digits", "abc%", "abc%"},
+		{"percent followed by non-hex", "a%zzb", "a%zzb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentDecode(tt.in); got != tt.want {
+				t.Errorf("percentDecode(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPercentRoundTrip(t *testing.T) {
+	inputs := []string{"", "plain", "100%", "line1\nline2\r\n", "\x00"}
+	for _, in := range inputs {
+		if got := percentDecode(percentEncode(in)); got != in {
+			t.Errorf("round trip for %q produced %q", in, got)
+		}
+	}
+}
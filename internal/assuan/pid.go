@@ -0,0 +1,7 @@
+package assuan
+
+import "os"
+
+func pid() int {
+	return os.Getpid()
+}
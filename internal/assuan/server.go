@@ -0,0 +1,246 @@
+// Package assuan implements the server side of the subset of the Assuan
+// protocol that gpg-agent speaks to a pinentry program: line-based commands
+// on stdin, "D"/"OK"/"ERR" framed responses on stdout. It exists so this
+// program can be gpg-agent's pinentry directly, without shelling out to
+// pinentry-mac (or depending on foxcpp/go-assuan) for the protocol itself.
+package assuan
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Callbacks are invoked as the corresponding Assuan commands arrive.
+type Callbacks struct {
+	GetPIN  func(Settings) (string, *Error)
+	Confirm func(Settings) (bool, *Error)
+	Msg     func(Settings) *Error
+}
+
+// Server speaks the Assuan protocol over r/w, dispatching to cb.
+type Server struct {
+	r  *bufio.Reader
+	w  *bufio.Writer
+	cb Callbacks
+	s  Settings
+}
+
+// NewServer returns a Server that reads commands from r and writes
+// responses to w.
+func NewServer(r io.Reader, w io.Writer, cb Callbacks) *Server {
+	return &Server{
+		r:  bufio.NewReader(r),
+		w:  bufio.NewWriter(w),
+		cb: cb,
+		s:  Settings{Options: map[string]string{}},
+	}
+}
+
+// Serve sends greeting as the initial banner and then services commands
+// until the client sends BYE or the connection is closed. It returns nil on
+// a clean BYE or when the client closes the connection (EOF), and otherwise
+// the I/O error that ended the session.
+func (srv *Server) Serve(greeting string) error {
+	if err := srv.writeOK(greeting); err != nil {
+		return err
+	}
+
+	for {
+		line, err := srv.readLine()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if line == "" {
+			continue
+		}
+
+		cmd, arg := splitCommand(line)
+		done, err := srv.dispatch(cmd, arg)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// dispatch handles a single command. The returned bool is true once the
+// client has sent BYE.
+func (srv *Server) dispatch(cmd, arg string) (bool, error) {
+	switch cmd {
+	case "SETDESC":
+		srv.s.Desc = percentDecode(arg)
+		return false, srv.writeOK("")
+	case "SETPROMPT":
+		srv.s.Prompt = percentDecode(arg)
+		return false, srv.writeOK("")
+	case "SETOK":
+		srv.s.Ok = percentDecode(arg)
+		return false, srv.writeOK("")
+	case "SETCANCEL":
+		srv.s.Cancel = percentDecode(arg)
+		return false, srv.writeOK("")
+	case "SETERROR":
+		srv.s.Error = percentDecode(arg)
+		return false, srv.writeOK("")
+	case "SETQUALITYBAR":
+		srv.s.Qualitybar = percentDecode(arg)
+		return false, srv.writeOK("")
+	case "SETTITLE":
+		srv.s.Title = percentDecode(arg)
+		return false, srv.writeOK("")
+	case "SETKEYINFO":
+		srv.s.KeyInfo = arg
+		return false, srv.writeOK("")
+	case "SETREPEAT":
+		srv.s.Repeat = true
+		return false, srv.writeOK("")
+	case "SETTIMEOUT":
+		if n, err := strconv.Atoi(arg); err == nil {
+			srv.s.Timeout = n
+		}
+		return false, srv.writeOK("")
+	case "OPTION":
+		return false, srv.handleOption(arg)
+	case "GETPIN":
+		return false, srv.handleGetPIN()
+	case "CONFIRM":
+		return false, srv.handleConfirm()
+	case "MESSAGE":
+		return false, srv.handleMessage()
+	case "RESET":
+		srv.s = Settings{Options: map[string]string{}}
+		return false, srv.writeOK("")
+	case "GETINFO":
+		return false, srv.handleGetInfo(arg)
+	case "BYE":
+		return true, srv.writeOK("closing connection")
+	case "NOP":
+		return false, srv.writeOK("")
+	default:
+		// gpg-agent probes for a number of optional commands
+		// (e.g. SETGENPIN, SETNOTOK). Treat anything we don't
+		// recognize as an unsupported, but non-fatal, request rather than
+		// killing the session.
+		return false, srv.writeErr(&Error{Code: 536870981, Message: "Unknown command"})
+	}
+}
+
+func (srv *Server) handleOption(arg string) error {
+	name, value, _ := strings.Cut(arg, " ")
+	switch name {
+	case "no-grab":
+		srv.s.NoGrab = true
+	default:
+		srv.s.Options[name] = percentDecode(value)
+	}
+	return srv.writeOK("")
+}
+
+func (srv *Server) handleGetPIN() error {
+	if srv.cb.GetPIN == nil {
+		return srv.writeErr(ErrCancelled)
+	}
+
+	pin, aerr := srv.cb.GetPIN(srv.s)
+	if aerr != nil {
+		return srv.writeErr(aerr)
+	}
+
+	if err := srv.writeData(pin); err != nil {
+		return err
+	}
+	return srv.writeOK("")
+}
+
+func (srv *Server) handleConfirm() error {
+	if srv.cb.Confirm == nil {
+		return srv.writeOK("")
+	}
+
+	ok, aerr := srv.cb.Confirm(srv.s)
+	if aerr != nil {
+		return srv.writeErr(aerr)
+	}
+	if !ok {
+		return srv.writeErr(ErrNotConfirmed)
+	}
+	return srv.writeOK("")
+}
+
+func (srv *Server) handleMessage() error {
+	if srv.cb.Msg == nil {
+		return srv.writeOK("")
+	}
+
+	if aerr := srv.cb.Msg(srv.s); aerr != nil {
+		return srv.writeErr(aerr)
+	}
+	return srv.writeOK("")
+}
+
+func (srv *Server) handleGetInfo(arg string) error {
+	switch arg {
+	case "pid":
+		if err := srv.writeData(strconv.Itoa(pid())); err != nil {
+			return err
+		}
+	default:
+		if err := srv.writeData(""); err != nil {
+			return err
+		}
+	}
+	return srv.writeOK("")
+}
+
+// readLine reads a single Assuan command line, stripping the trailing
+// CR/LF.
+func (srv *Server) readLine() (string, error) {
+	line, err := srv.r.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// splitCommand separates a line into its command keyword and the
+// (still percent-encoded) remainder of the line.
+func splitCommand(line string) (cmd, arg string) {
+	cmd, arg, _ = strings.Cut(line, " ")
+	return cmd, arg
+}
+
+func (srv *Server) writeOK(comment string) error {
+	if comment == "" {
+		_, err := fmt.Fprint(srv.w, "OK\n")
+		if err != nil {
+			return err
+		}
+	} else {
+		if _, err := fmt.Fprintf(srv.w, "OK %s\n", comment); err != nil {
+			return err
+		}
+	}
+	return srv.w.Flush()
+}
+
+func (srv *Server) writeErr(e *Error) error {
+	if _, err := fmt.Fprintf(srv.w, "ERR %d %s\n", e.Code, e.Message); err != nil {
+		return err
+	}
+	return srv.w.Flush()
+}
+
+func (srv *Server) writeData(data string) error {
+	if _, err := fmt.Fprintf(srv.w, "D %s\n", percentEncode(data)); err != nil {
+		return err
+	}
+	return srv.w.Flush()
+}
@@ -0,0 +1,165 @@
+package assuan
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+// newTestServer builds a Server with an already-primed bufio.Writer so
+// dispatch can be exercised directly without going through Serve's read
+// loop.
+func newTestServer(cb Callbacks) (*Server, *strings.Builder) {
+	var out strings.Builder
+	srv := &Server{
+		r:  bufio.NewReader(strings.NewReader("")),
+		w:  bufio.NewWriter(&out),
+		cb: cb,
+		s:  Settings{Options: map[string]string{}},
+	}
+	return srv, &out
+}
+
+func TestDispatchSetters(t *testing.T) {
+	srv, out := newTestServer(Callbacks{})
+
+	if _, err := srv.dispatch("SETDESC", "hello%20world"); err != nil {
+		t.Fatalf("dispatch SETDESC: %v", err)
+	}
+	if srv.s.Desc != "hello world" {
+		t.Errorf("Desc = %q, want %q", srv.s.Desc, "hello world")
+	}
+	if !strings.Contains(out.String(), "OK\n") {
+		t.Errorf("expected OK response, got %q", out.String())
+	}
+}
+
+func TestDispatchSetKeyInfoNotPercentDecoded(t *testing.T) {
+	srv, _ := newTestServer(Callbacks{})
+
+	if _, err := srv.dispatch("SETKEYINFO", "x/cacheid%20with%20percent"); err != nil {
+		t.Fatalf("dispatch SETKEYINFO: %v", err)
+	}
+	if srv.s.KeyInfo != "x/cacheid%20with%20percent" {
+		t.Errorf("KeyInfo = %q, want the raw (non-decoded) argument", srv.s.KeyInfo)
+	}
+}
+
+func TestDispatchGetPIN(t *testing.T) {
+	cb := Callbacks{
+		GetPIN: func(s Settings) (string, *Error) {
+			return "1234", nil
+		},
+	}
+	srv, out := newTestServer(cb)
+
+	if _, err := srv.dispatch("GETPIN", ""); err != nil {
+		t.Fatalf("dispatch GETPIN: %v", err)
+	}
+	if got, want := out.String(), "D 1234\nOK\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestDispatchGetPINError(t *testing.T) {
+	cb := Callbacks{
+		GetPIN: func(s Settings) (string, *Error) {
+			return "", ErrCancelled
+		},
+	}
+	srv, out := newTestServer(cb)
+
+	if _, err := srv.dispatch("GETPIN", ""); err != nil {
+		t.Fatalf("dispatch GETPIN: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "ERR 83886179 ") {
+		t.Errorf("output = %q, want an ERR line for ErrCancelled", out.String())
+	}
+}
+
+func TestDispatchConfirm(t *testing.T) {
+	cb := Callbacks{
+		Confirm: func(s Settings) (bool, *Error) {
+			return false, nil
+		},
+	}
+	srv, out := newTestServer(cb)
+
+	if _, err := srv.dispatch("CONFIRM", ""); err != nil {
+		t.Fatalf("dispatch CONFIRM: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "ERR 83886194 ") {
+		t.Errorf("output = %q, want an ERR line for ErrNotConfirmed", out.String())
+	}
+}
+
+func TestDispatchMessage(t *testing.T) {
+	called := false
+	cb := Callbacks{
+		Msg: func(s Settings) *Error {
+			called = true
+			return nil
+		},
+	}
+	srv, out := newTestServer(cb)
+
+	if _, err := srv.dispatch("MESSAGE", ""); err != nil {
+		t.Fatalf("dispatch MESSAGE: %v", err)
+	}
+	if !called {
+		t.Error("Msg callback was not invoked")
+	}
+	if out.String() != "OK\n" {
+		t.Errorf("output = %q, want only an OK line", out.String())
+	}
+}
+
+func TestDispatchBye(t *testing.T) {
+	srv, out := newTestServer(Callbacks{})
+
+	done, err := srv.dispatch("BYE", "")
+	if err != nil {
+		t.Fatalf("dispatch BYE: %v", err)
+	}
+	if !done {
+		t.Error("dispatch BYE should report done = true")
+	}
+	if !strings.HasPrefix(out.String(), "OK ") {
+		t.Errorf("output = %q, want an OK line", out.String())
+	}
+}
+
+func TestDispatchUnknownCommand(t *testing.T) {
+	srv, out := newTestServer(Callbacks{})
+
+	done, err := srv.dispatch("FROBNICATE", "")
+	if err != nil {
+		t.Fatalf("dispatch FROBNICATE: %v", err)
+	}
+	if done {
+		t.Error("an unknown command should not end the session")
+	}
+	if !strings.HasPrefix(out.String(), "ERR ") {
+		t.Errorf("output = %q, want an ERR line", out.String())
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		line    string
+		wantCmd string
+		wantArg string
+	}{
+		{"GETPIN", "GETPIN", ""},
+		{"SETDESC hello", "SETDESC", "hello"},
+		{"OPTION no-grab", "OPTION", "no-grab"},
+		{"SETDESC a b c", "SETDESC", "a b c"},
+	}
+
+	for _, tt := range tests {
+		cmd, arg := splitCommand(tt.line)
+		if cmd != tt.wantCmd || arg != tt.wantArg {
+			t.Errorf("splitCommand(%q) = (%q, %q), want (%q, %q)", tt.line, cmd, arg, tt.wantCmd, tt.wantArg)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package assuan
+
+// Settings accumulates the state gpg-agent sends via SETDESC, SETPROMPT and
+// friends before issuing a GETPIN, CONFIRM or MESSAGE command.
+type Settings struct {
+	Desc       string
+	Prompt     string
+	Ok         string
+	Cancel     string
+	Error      string
+	Qualitybar string
+	Title      string
+	KeyInfo    string
+	Repeat     bool
+	Timeout    int
+	// NoGrab mirrors the "no-grab" OPTION: the caller asked us not to grab
+	// the keyboard/pointer while prompting.
+	NoGrab bool
+	// Options holds every OPTION the client sent, keyed by name, for
+	// anything not promoted to a dedicated field above.
+	Options map[string]string
+}
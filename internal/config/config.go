@@ -0,0 +1,142 @@
+// Package config parses the per-user policy file at
+// ~/.config/pinentry-touchid/config.toml, which lets a user express, per
+// keygrip, whether a PIN may be cached in the Keychain at all, how it
+// should be unlocked, and for how long a Touch ID evaluation may be reused.
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Method selects how a cached PIN is unlocked.
+type Method string
+
+const (
+	// MethodTouchID requires Touch ID, from the enrollment set selected by
+	// Biometry, to read the cached PIN from the Keychain. None of the
+	// Biometry values accepts the device passcode instead.
+	MethodTouchID Method = "touchid"
+	// MethodWatch behaves like MethodTouchID: LAContext accepts an
+	// unlocked Apple Watch as a substitute for Touch ID on supported
+	// hardware, so no separate code path is needed.
+	MethodWatch Method = "watch"
+	// MethodPassword never touches the Keychain; GETPIN always prompts.
+	MethodPassword Method = "password"
+)
+
+// Biometry selects which enrolled biometry a Keychain item accepts, mirroring
+// keychain.Biometry in the config file's vocabulary.
+type Biometry string
+
+const (
+	// BiometryCurrentSet accepts only the fingerprints/faces enrolled at
+	// the time the item was created.
+	BiometryCurrentSet Biometry = "current-set"
+	// BiometryAny accepts any biometry enrolled at the time of access.
+	BiometryAny Biometry = "any"
+)
+
+// KeyPolicy is the policy that applies to a single keygrip.
+type KeyPolicy struct {
+	Keygrip  string   `toml:"keygrip"`
+	Method   Method   `toml:"method"`
+	Biometry Biometry `toml:"biometry"`
+	// AllowableReuseDurationSeconds only suppresses re-prompts across
+	// multiple GETPIN requests handled by the same pinentry process; see
+	// keychain.AccessPolicy.AllowableReuseDuration. It has no effect across
+	// gpg-agent's usual per-prompt pinentry spawns. It is a pointer so that
+	// PolicyFor can tell "not set in this entry" (nil) apart from an
+	// explicit "0: never reuse an evaluation" override.
+	AllowableReuseDurationSeconds *int `toml:"allowable_reuse_duration_seconds"`
+}
+
+// Config is the parsed contents of config.toml.
+type Config struct {
+	// Default is applied to any keygrip with no entry in Keys; its Keygrip
+	// field is meaningless and ignored.
+	Default KeyPolicy `toml:"default"`
+	// Keys holds the per-keygrip overrides of Default.
+	Keys []KeyPolicy `toml:"keys"`
+	// DenyList holds keygrips that must never be cached in the Keychain,
+	// regardless of what Keys says; GETPIN always prompts for them.
+	DenyList []string `toml:"deny_list"`
+}
+
+// defaultConfig is what config.toml is decoded over, so any field the file
+// does not set (including in its [default] table) keeps the value given
+// here. It is also what Load returns when no config file exists at all.
+var defaultConfig = Config{
+	Default: KeyPolicy{
+		Method:   MethodTouchID,
+		Biometry: BiometryCurrentSet,
+	},
+}
+
+func defaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pinentry-touchid", "config.toml"), nil
+}
+
+// Load reads config.toml from its default location. A missing file is not
+// an error: Load returns the built-in default policy instead.
+func Load() (*Config, error) {
+	path, err := defaultPath()
+	if err != nil {
+		return nil, err
+	}
+	return LoadFile(path)
+}
+
+// LoadFile reads config.toml from path.
+func LoadFile(path string) (*Config, error) {
+	cfg := defaultConfig
+	if _, err := toml.DecodeFile(path, &cfg); err != nil {
+		if os.IsNotExist(err) {
+			return &cfg, nil
+		}
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// PolicyFor returns the policy that applies to keygrip: Default, overridden
+// field-by-field by whichever fields the matching entry in Keys (if any)
+// actually sets. A per-key entry that only sets, say,
+// AllowableReuseDurationSeconds does not reset Method or Biometry back to
+// their zero values; it only changes what it explicitly sets.
+func (c *Config) PolicyFor(keygrip string) KeyPolicy {
+	policy := c.Default
+	for _, k := range c.Keys {
+		if k.Keygrip != keygrip {
+			continue
+		}
+		if k.Method != "" {
+			policy.Method = k.Method
+		}
+		if k.Biometry != "" {
+			policy.Biometry = k.Biometry
+		}
+		if k.AllowableReuseDurationSeconds != nil {
+			policy.AllowableReuseDurationSeconds = k.AllowableReuseDurationSeconds
+		}
+		break
+	}
+	return policy
+}
+
+// Denied reports whether keygrip is on the deny-list, i.e. must never be
+// cached in the Keychain regardless of KeyPolicy.
+func (c *Config) Denied(keygrip string) bool {
+	for _, g := range c.DenyList {
+		if g == keygrip {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func intPtr(n int) *int { return &n }
+
+func TestLoadFileMissingFileReturnsDefault(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "does-not-exist.toml")
+
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	if cfg.Default.Method != MethodTouchID {
+		t.Errorf("Default.Method = %q, want %q", cfg.Default.Method, MethodTouchID)
+	}
+	if cfg.Default.Biometry != BiometryCurrentSet {
+		t.Errorf("Default.Biometry = %q, want %q", cfg.Default.Biometry, BiometryCurrentSet)
+	}
+	if len(cfg.Keys) != 0 || len(cfg.DenyList) != 0 {
+		t.Errorf("expected no Keys or DenyList, got %+v", cfg)
+	}
+}
+
+func TestLoadFileParsesConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	contents := `
+deny_list = ["BBBB"]
+
+[default]
+method = "password"
+biometry = "any"
+
+[[keys]]
+keygrip = "AAAA"
+method = "touchid"
+biometry = "current-set"
+allowable_reuse_duration_seconds = 30
+`
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	if cfg.Default.Method != MethodPassword {
+		t.Errorf("Default.Method = %q, want %q", cfg.Default.Method, MethodPassword)
+	}
+	if cfg.Default.Biometry != BiometryAny {
+		t.Errorf("Default.Biometry = %q, want %q", cfg.Default.Biometry, BiometryAny)
+	}
+	if len(cfg.Keys) != 1 || cfg.Keys[0].Keygrip != "AAAA" {
+		t.Errorf("Keys = %+v, want a single entry for keygrip AAAA", cfg.Keys)
+	}
+	if got := cfg.Keys[0].AllowableReuseDurationSeconds; got == nil || *got != 30 {
+		t.Errorf("Keys[0].AllowableReuseDurationSeconds = %v, want 30", got)
+	}
+	if !cfg.Denied("BBBB") {
+		t.Error("expected BBBB to be on the deny-list")
+	}
+}
+
+func TestPolicyForFallsBackToDefault(t *testing.T) {
+	cfg := &Config{
+		Default: KeyPolicy{Method: MethodTouchID, Biometry: BiometryCurrentSet, AllowableReuseDurationSeconds: intPtr(30)},
+	}
+
+	policy := cfg.PolicyFor("unknown-keygrip")
+	if policy.Method != cfg.Default.Method || policy.Biometry != cfg.Default.Biometry {
+		t.Errorf("PolicyFor(unknown) = %+v, want Default %+v", policy, cfg.Default)
+	}
+	if policy.AllowableReuseDurationSeconds == nil || *policy.AllowableReuseDurationSeconds != 30 {
+		t.Errorf("AllowableReuseDurationSeconds = %v, want 30", policy.AllowableReuseDurationSeconds)
+	}
+}
+
+func TestPolicyForMergesPartialOverrideOntoDefault(t *testing.T) {
+	cfg := &Config{
+		Default: KeyPolicy{Method: MethodPassword, Biometry: BiometryAny},
+		Keys: []KeyPolicy{
+			{Keygrip: "AAAA", AllowableReuseDurationSeconds: intPtr(30)},
+		},
+	}
+
+	policy := cfg.PolicyFor("AAAA")
+	if policy.Method != MethodPassword {
+		t.Errorf("Method = %q, want the Default's %q to survive an override that doesn't set it", policy.Method, MethodPassword)
+	}
+	if policy.Biometry != BiometryAny {
+		t.Errorf("Biometry = %q, want the Default's %q to survive an override that doesn't set it", policy.Biometry, BiometryAny)
+	}
+	if policy.AllowableReuseDurationSeconds == nil || *policy.AllowableReuseDurationSeconds != 30 {
+		t.Errorf("AllowableReuseDurationSeconds = %v, want 30", policy.AllowableReuseDurationSeconds)
+	}
+}
+
+func TestPolicyForExplicitZeroReuseDurationOverridesDefault(t *testing.T) {
+	cfg := &Config{
+		Default: KeyPolicy{Method: MethodTouchID, AllowableReuseDurationSeconds: intPtr(30)},
+		Keys: []KeyPolicy{
+			{Keygrip: "AAAA", AllowableReuseDurationSeconds: intPtr(0)},
+		},
+	}
+
+	policy := cfg.PolicyFor("AAAA")
+	if policy.AllowableReuseDurationSeconds == nil || *policy.AllowableReuseDurationSeconds != 0 {
+		t.Errorf("AllowableReuseDurationSeconds = %v, want an explicit 0, not the Default's 30", policy.AllowableReuseDurationSeconds)
+	}
+}
+
+func TestPolicyForFullOverrideReplacesAllFields(t *testing.T) {
+	cfg := &Config{
+		Default: KeyPolicy{Method: MethodPassword, Biometry: BiometryAny},
+		Keys: []KeyPolicy{
+			{
+				Keygrip:                       "AAAA",
+				Method:                        MethodTouchID,
+				Biometry:                      BiometryCurrentSet,
+				AllowableReuseDurationSeconds: intPtr(60),
+			},
+		},
+	}
+
+	policy := cfg.PolicyFor("AAAA")
+	if policy.Method != MethodTouchID {
+		t.Errorf("Method = %q, want %q", policy.Method, MethodTouchID)
+	}
+	if policy.Biometry != BiometryCurrentSet {
+		t.Errorf("Biometry = %q, want %q", policy.Biometry, BiometryCurrentSet)
+	}
+	if policy.AllowableReuseDurationSeconds == nil || *policy.AllowableReuseDurationSeconds != 60 {
+		t.Errorf("AllowableReuseDurationSeconds = %v, want 60", policy.AllowableReuseDurationSeconds)
+	}
+}
+
+func TestDenied(t *testing.T) {
+	cfg := &Config{DenyList: []string{"AAAA", "BBBB"}}
+
+	if !cfg.Denied("AAAA") {
+		t.Error("expected AAAA to be denied")
+	}
+	if cfg.Denied("CCCC") {
+		t.Error("expected CCCC not to be denied")
+	}
+}
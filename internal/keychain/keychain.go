@@ -0,0 +1,75 @@
+// Package keychain provides access to Touch ID-gated Keychain items backed
+// by the macOS Data Protection Keychain. Unlike the legacy file-based
+// Keychain (as used by github.com/keybase/go-keychain against generic
+// passwords), items stored here carry a SecAccessControl object, so the
+// operating system itself refuses SecItemCopyMatching until the caller has
+// satisfied the configured biometry policy. There is no "Always Allow"
+// prompt to click through, and no separate Touch ID check that could be
+// skipped by a caller that goes straight to the Keychain.
+package keychain
+
+import "errors"
+
+// ErrNotFound is returned by Load when no item matches the given account.
+var ErrNotFound = errors.New("keychain: item not found")
+
+// ErrDuplicateItem is returned by Store when an item with the given account
+// already exists.
+var ErrDuplicateItem = errors.New("keychain: item already exists")
+
+// Biometry selects which class of biometric enrollment SecAccessControl
+// should accept.
+type Biometry int
+
+const (
+	// BiometryCurrentSet requires the exact set of fingerprints/faces that
+	// was enrolled at the time the item was created. Enrolling or removing
+	// a fingerprint invalidates the item, forcing it to be re-created.
+	BiometryCurrentSet Biometry = iota
+	// BiometryAny accepts any biometry enrolled on the device at the time
+	// of access, including ones added after the item was created.
+	BiometryAny
+	// UserPresence falls back to the device passcode if biometry is
+	// unavailable, in addition to accepting any enrolled biometry.
+	UserPresence
+)
+
+// AccessPolicy configures the SecAccessControl attached to a Keychain item
+// and the LAContext used to evaluate it.
+type AccessPolicy struct {
+	// Biometry selects which enrollment set is accepted.
+	Biometry Biometry
+	// AllowableReuseDuration is how many seconds a successful Touch ID
+	// evaluation may be reused for without prompting again. Zero means
+	// every access re-prompts. The reuse window only applies to Load calls
+	// for the same account that share the same LAContext (see
+	// keychain_darwin.go's sharedContext); it never lets an evaluation for
+	// one account authorize another's item, and it cannot suppress a
+	// re-prompt in a separate process, so it has no effect across
+	// gpg-agent's usual per-prompt pinentry spawns.
+	AllowableReuseDuration int
+}
+
+// Store saves pin under account, protected by an access control object
+// built from policy. account is the item's matching key (SecAttrAccount);
+// it should be unpredictable (see internal/accountindex) so that another
+// application cannot sniff the PIN by querying for a known label. label is
+// recorded purely for display in the Keychain app and is never used to
+// locate the item.
+func Store(account, label string, pin []byte, policy AccessPolicy) error {
+	return storePlatform(account, label, pin, policy)
+}
+
+// Load retrieves the pin stored under account, evaluating policy via an
+// LAContext. The OS prompts for Touch ID (or the configured fallback) as
+// part of the underlying SecItemCopyMatching call; Load only returns once
+// that evaluation has succeeded or failed.
+func Load(account string, policy AccessPolicy) ([]byte, error) {
+	return loadPlatform(account, policy)
+}
+
+// Exists reports whether an item matching account is present, without
+// requiring any authentication (it does not request the item's data).
+func Exists(account string) (bool, error) {
+	return existsPlatform(account)
+}
@@ -0,0 +1,248 @@
+package keychain
+
+/*
+#cgo LDFLAGS: -framework Security -framework LocalAuthentication -framework CoreFoundation
+// This preamble uses the LAContext class and Objective-C syntax (message
+// sends, property access, __bridge casts), so it must be compiled as
+// Objective-C rather than cgo's default of plain C. ARC is off: contexts
+// created here are intentionally never released (see sharedContext in the
+// Go code below), so plain alloc/init reference counting, with no
+// autorelease surprises, is what we want.
+#cgo CFLAGS: -x objective-c -fno-objc-arc
+#include <stdlib.h>
+#include <Security/Security.h>
+#include <LocalAuthentication/LocalAuthentication.h>
+
+// buildAccessControl creates a SecAccessControlRef requiring the given
+// biometry class plus kSecAttrAccessibleWhenUnlockedThisDeviceOnly. The
+// caller owns the returned reference.
+static SecAccessControlRef buildAccessControl(int biometry, CFErrorRef *error) {
+	SecAccessControlCreateFlags flags;
+	switch (biometry) {
+	case 1: // BiometryAny
+		flags = kSecAccessControlBiometryAny;
+		break;
+	case 2: // UserPresence
+		flags = kSecAccessControlUserPresence;
+		break;
+	default: // BiometryCurrentSet
+		flags = kSecAccessControlBiometryCurrentSet;
+		break;
+	}
+
+	return SecAccessControlCreateWithFlags(
+		kCFAllocatorDefault,
+		kSecAttrAccessibleWhenUnlockedThisDeviceOnly,
+		flags,
+		error);
+}
+
+// storeItem adds a generic password item protected by access, using the
+// Data Protection Keychain rather than the legacy file-based one.
+static OSStatus storeItem(CFStringRef label, CFStringRef account, CFDataRef data, SecAccessControlRef access) {
+	CFMutableDictionaryRef query = CFDictionaryCreateMutable(kCFAllocatorDefault, 0,
+		&kCFTypeDictionaryKeyCallBacks, &kCFTypeDictionaryValueCallBacks);
+
+	CFDictionaryAddValue(query, kSecClass, kSecClassGenericPassword);
+	CFDictionaryAddValue(query, kSecUseDataProtectionKeychain, kCFBooleanTrue);
+	CFDictionaryAddValue(query, kSecAttrService, CFSTR("GnuPG"));
+	CFDictionaryAddValue(query, kSecAttrLabel, label);
+	CFDictionaryAddValue(query, kSecAttrAccount, account);
+	CFDictionaryAddValue(query, kSecValueData, data);
+	CFDictionaryAddValue(query, kSecAttrAccessControl, access);
+
+	OSStatus status = SecItemAdd(query, NULL);
+	CFRelease(query);
+	return status;
+}
+
+// loadItem fetches the data for the generic password item matching account,
+// evaluating ctx (an LAContext) to satisfy the item's access control.
+// Deliberately matches on kSecAttrAccount rather than kSecAttrLabel: the
+// caller is expected to pass an unpredictable account name (see
+// internal/accountindex) so that another application cannot sniff the PIN
+// by querying for a known label.
+static OSStatus loadItem(CFStringRef account, LAContext *ctx, CFDataRef *outData) {
+	CFMutableDictionaryRef query = CFDictionaryCreateMutable(kCFAllocatorDefault, 0,
+		&kCFTypeDictionaryKeyCallBacks, &kCFTypeDictionaryValueCallBacks);
+
+	CFDictionaryAddValue(query, kSecClass, kSecClassGenericPassword);
+	CFDictionaryAddValue(query, kSecUseDataProtectionKeychain, kCFBooleanTrue);
+	CFDictionaryAddValue(query, kSecAttrAccount, account);
+	CFDictionaryAddValue(query, kSecMatchLimit, kSecMatchLimitOne);
+	CFDictionaryAddValue(query, kSecReturnData, kCFBooleanTrue);
+	CFDictionaryAddValue(query, kSecUseAuthenticationContext, (__bridge id)ctx);
+
+	CFTypeRef result = NULL;
+	OSStatus status = SecItemCopyMatching(query, &result);
+	CFRelease(query);
+
+	if (status == errSecSuccess) {
+		*outData = (CFDataRef)result;
+	}
+	return status;
+}
+
+// existsItem reports whether an item matching account is present, without
+// requesting its data or triggering any authentication.
+static OSStatus existsItem(CFStringRef account) {
+	CFMutableDictionaryRef query = CFDictionaryCreateMutable(kCFAllocatorDefault, 0,
+		&kCFTypeDictionaryKeyCallBacks, &kCFTypeDictionaryValueCallBacks);
+
+	CFDictionaryAddValue(query, kSecClass, kSecClassGenericPassword);
+	CFDictionaryAddValue(query, kSecUseDataProtectionKeychain, kCFBooleanTrue);
+	CFDictionaryAddValue(query, kSecAttrAccount, account);
+	CFDictionaryAddValue(query, kSecMatchLimit, kSecMatchLimitOne);
+	CFDictionaryAddValue(query, kSecReturnData, kCFBooleanFalse);
+	CFDictionaryAddValue(query, kSecReturnAttributes, kCFBooleanTrue);
+
+	CFTypeRef result = NULL;
+	OSStatus status = SecItemCopyMatching(query, &result);
+	if (result) {
+		CFRelease(result);
+	}
+	CFRelease(query);
+	return status;
+}
+
+// newContext creates an LAContext configured with the given reuse duration,
+// in seconds, for Touch ID evaluations.
+static LAContext *newContext(double reuseDuration) {
+	LAContext *ctx = [[LAContext alloc] init];
+	ctx.touchIDAuthenticationAllowableReuseDuration = reuseDuration;
+	return ctx;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+var (
+	contextMu sync.Mutex
+	// contexts caches one LAContext per account, so that
+	// touchIDAuthenticationAllowableReuseDuration has an evaluation to
+	// actually reuse. A context created fresh on every Load call would never
+	// see a prior evaluation, making the reuse duration a no-op; sharing one
+	// per account fixes that for multiple Load calls against the same item
+	// within a single pinentry invocation (e.g. several GETPIN requests for
+	// the same keygrip in one Assuan session). Caching is per account, not
+	// global, so that an evaluation for one keygrip can never be reused to
+	// authorize a different keygrip's item. gpg-agent typically spawns a new
+	// pinentry process per prompt, though, and this cache does not survive
+	// that: a non-zero reuse duration still cannot suppress a re-prompt in a
+	// freshly spawned process, since there is no prior evaluation in that
+	// process to reuse. Cached contexts are never released, and neither are
+	// contexts dropped by evictContext: one is leaked per keygrip the
+	// process is asked about, plus one per failed or cancelled Load, which
+	// is a small, short-lived leak for a single pinentry process.
+	contexts = map[string]*C.LAContext{}
+)
+
+// sharedContext returns the LAContext used for Touch ID evaluations of
+// account, configured for reuseDuration and created (and cached) on first
+// use.
+func sharedContext(account string, reuseDuration int) *C.LAContext {
+	contextMu.Lock()
+	defer contextMu.Unlock()
+
+	if ctx, ok := contexts[account]; ok {
+		return ctx
+	}
+	ctx := C.newContext(C.double(reuseDuration))
+	contexts[account] = ctx
+	return ctx
+}
+
+// evictContext drops any cached LAContext for account, so the next
+// sharedContext call for it creates a fresh one instead of reusing one that
+// may have been left in a failed or cancelled state.
+func evictContext(account string) {
+	contextMu.Lock()
+	defer contextMu.Unlock()
+	delete(contexts, account)
+}
+
+func cfString(s string) C.CFStringRef {
+	cstr := C.CString(s)
+	defer C.free(unsafe.Pointer(cstr))
+	return C.CFStringCreateWithCString(C.kCFAllocatorDefault, cstr, C.kCFStringEncodingUTF8)
+}
+
+func buildAccess(policy AccessPolicy) (C.SecAccessControlRef, error) {
+	var cferr C.CFErrorRef
+	access := C.buildAccessControl(C.int(policy.Biometry), &cferr)
+	if access == 0 {
+		return 0, fmt.Errorf("keychain: failed to create access control (CFErrorRef %v)", cferr)
+	}
+	return access, nil
+}
+
+func storePlatform(account, label string, pin []byte, policy AccessPolicy) error {
+	access, err := buildAccess(policy)
+	if err != nil {
+		return err
+	}
+	defer C.CFRelease(C.CFTypeRef(access))
+
+	cfLabel := cfString(label)
+	defer C.CFRelease(C.CFTypeRef(cfLabel))
+	cfAccount := cfString(account)
+	defer C.CFRelease(C.CFTypeRef(cfAccount))
+
+	cfData := C.CFDataCreate(C.kCFAllocatorDefault, (*C.UInt8)(unsafe.Pointer(&pin[0])), C.CFIndex(len(pin)))
+	defer C.CFRelease(C.CFTypeRef(cfData))
+
+	status := C.storeItem(cfLabel, cfAccount, cfData, access)
+	switch status {
+	case C.errSecSuccess:
+		return nil
+	case C.errSecDuplicateItem:
+		return ErrDuplicateItem
+	default:
+		return fmt.Errorf("keychain: SecItemAdd failed with status %d", status)
+	}
+}
+
+func loadPlatform(account string, policy AccessPolicy) ([]byte, error) {
+	ctx := sharedContext(account, policy.AllowableReuseDuration)
+
+	cfAccount := cfString(account)
+	defer C.CFRelease(C.CFTypeRef(cfAccount))
+
+	var cfData C.CFDataRef
+	status := C.loadItem(cfAccount, ctx, &cfData)
+	switch status {
+	case C.errSecSuccess:
+		defer C.CFRelease(C.CFTypeRef(cfData))
+		length := C.CFDataGetLength(cfData)
+		bytes := C.GoBytes(unsafe.Pointer(C.CFDataGetBytePtr(cfData)), C.int(length))
+		return bytes, nil
+	case C.errSecItemNotFound:
+		return nil, ErrNotFound
+	default:
+		// A failed or cancelled evaluation can leave ctx unusable for future
+		// calls, so evict it rather than keep reusing it; the next Load for
+		// this account will get a fresh context.
+		evictContext(account)
+		return nil, fmt.Errorf("keychain: SecItemCopyMatching failed with status %d", status)
+	}
+}
+
+func existsPlatform(account string) (bool, error) {
+	cfAccount := cfString(account)
+	defer C.CFRelease(C.CFTypeRef(cfAccount))
+
+	status := C.existsItem(cfAccount)
+	switch status {
+	case C.errSecSuccess:
+		return true, nil
+	case C.errSecItemNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("keychain: SecItemCopyMatching failed with status %d", status)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !darwin
+
+package keychain
+
+import (
+	"errors"
+	"runtime"
+)
+
+// errUnsupported is returned by every platform function on non-darwin
+// builds; this package only has a real implementation backed by the macOS
+// Data Protection Keychain and LocalAuthentication frameworks.
+var errUnsupported = errors.New("keychain: not supported on " + runtime.GOOS)
+
+func storePlatform(account, label string, pin []byte, policy AccessPolicy) error {
+	return errUnsupported
+}
+
+func loadPlatform(account string, policy AccessPolicy) ([]byte, error) {
+	return nil, errUnsupported
+}
+
+func existsPlatform(account string) (bool, error) {
+	return false, errUnsupported
+}
@@ -0,0 +1,64 @@
+// Package logging provides the leveled, rotating logger used throughout
+// pinentry-touchid. Earlier versions of this program hard-coded
+// /tmp/test.log at mode 0666, which is both world-writable and a poor home
+// for a log that may end up recording details about PIN prompts; this
+// package instead defaults to a per-user cache directory at mode 0600 and
+// rotates the file once it grows too large.
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultMaxBytes is the size at which the active log file is rotated.
+const DefaultMaxBytes = 5 * 1024 * 1024
+
+// DefaultMaxBackups is how many rotated files are kept alongside the active
+// one (agent.log.1 through agent.log.<DefaultMaxBackups>).
+const DefaultMaxBackups = 3
+
+// DefaultPath returns the default log location, under the user's cache
+// directory. It does not create the directory; New does that when it opens
+// the file.
+func DefaultPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pinentry-touchid", "agent.log"), nil
+}
+
+// ParseLevel maps the strings accepted by --log-level (whose default is
+// taken from the PINENTRY_TOUCHID_LOG_LEVEL env var) to a slog.Level.
+// config.toml has no log level setting. Anything unrecognized is treated
+// as "info".
+func ParseLevel(s string) slog.Level {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// New opens (creating it and its directory if necessary) the log file at
+// path, and returns a leveled logger writing to it along with a close
+// function the caller should defer. The file is rotated on a later write
+// that would take it past DefaultMaxBytes, not when it is opened.
+func New(path string, level slog.Level) (*slog.Logger, func() error, error) {
+	w, err := newRotatingFile(path, DefaultMaxBytes, DefaultMaxBackups)
+	if err != nil {
+		return nil, nil, fmt.Errorf("logging: opening %s: %w", path, err)
+	}
+
+	handler := slog.NewTextHandler(w, &slog.HandlerOptions{Level: level})
+	return slog.New(handler), w.Close, nil
+}
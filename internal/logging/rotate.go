@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// rotatingFile is an io.Writer over a log file that renames the file aside
+// before a write that would take it past maxBytes, keeping up to maxBackups
+// old generations. With maxBackups of 0 the file is only reopened, never
+// renamed or truncated, so it keeps growing.
+type rotatingFile struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+func newRotatingFile(path string, maxBytes int64, maxBackups int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	f := &rotatingFile{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		// Earlier generations may not exist yet; that's fine, os.Rename's
+		// error is simply ignored for them.
+		os.Rename(f.generation(i), f.generation(i+1))
+	}
+	if f.maxBackups > 0 {
+		os.Rename(f.path, f.generation(1))
+	}
+
+	return f.open()
+}
+
+func (f *rotatingFile) generation(n int) string {
+	return fmt.Sprintf("%s.%d", f.path, n)
+}
+
+func (f *rotatingFile) Close() error {
+	return f.file.Close()
+}
@@ -1,18 +1,21 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"regexp"
 	"strings"
 
-	"github.com/foxcpp/go-assuan/common"
-	"github.com/foxcpp/go-assuan/pinentry"
 	pinentryBinary "github.com/gopasspw/pinentry"
-	"github.com/keybase/go-keychain"
-	touchid "github.com/lox/go-touchid"
+
+	"github.com/cathyjf/pinentry-touchid/internal/accountindex"
+	"github.com/cathyjf/pinentry-touchid/internal/assuan"
+	"github.com/cathyjf/pinentry-touchid/internal/config"
+	"github.com/cathyjf/pinentry-touchid/internal/keychain"
+	"github.com/cathyjf/pinentry-touchid/internal/logging"
 )
 
 var (
@@ -21,107 +24,81 @@ var (
 	// keyID should be of exactly 8 or 16 characters
 )
 
-const (
-	DefaultLogLocation = "/tmp/test.log"
-	DefaultLoggerFlags = log.Ldate | log.Ltime | log.Lshortfile
-)
+// accessPolicyForKey translates a key's config.KeyPolicy into the
+// keychain.AccessPolicy enforced by the OS when reading its cached PIN.
+func accessPolicyForKey(kp config.KeyPolicy) keychain.AccessPolicy {
+	biometry := keychain.BiometryCurrentSet
+	if kp.Biometry == config.BiometryAny {
+		biometry = keychain.BiometryAny
+	}
 
-// checkEntryInKeychain executes a search in the current keychain. The search configured to not
-// return the Data stored in the Keychain, as a result this should not require any type of
-// authentication.
-func checkEntryInKeychain(label string) (bool, error) {
-	query := keychain.NewItem()
-	query.SetSecClass(keychain.SecClassGenericPassword)
-	query.SetLabel(label)
-	query.SetMatchLimit(keychain.MatchLimitOne)
-	query.SetReturnData(false)
-	query.SetReturnAttributes(true)
-
-	results, err := keychain.QueryItem(query)
-	if err != nil {
-		return false, err
+	var reuseDuration int
+	if kp.AllowableReuseDurationSeconds != nil {
+		reuseDuration = *kp.AllowableReuseDurationSeconds
 	}
 
-	return len(results) == 1, nil
+	return keychain.AccessPolicy{
+		Biometry:               biometry,
+		AllowableReuseDuration: reuseDuration,
+	}
+}
+
+// checkEntryInKeychain reports whether an item matching account exists.
+// This query does not request the item's data, so it does not require Touch
+// ID.
+func checkEntryInKeychain(account string) (bool, error) {
+	return keychain.Exists(account)
 }
 
 // KeychainClient represents a single instance of a pinentry server
 type KeychainClient struct {
-	logger *log.Logger
+	logger *slog.Logger
 }
 
-func New() KeychainClient {
-	var logger *log.Logger
-
-	if _, err := os.Stat(DefaultLogLocation); os.IsNotExist(err) {
-		file, err := os.Create(DefaultLogLocation)
-		if err != nil {
-			panic("Couldn't create log file")
-		}
-
-		logger = log.New(file, "", DefaultLoggerFlags)
-	} else {
-		// append to the existing log file
-		file, err := os.OpenFile(DefaultLogLocation, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
-		if err != nil {
-			panic(err)
-		}
-
-		logger = log.New(file, "", DefaultLoggerFlags)
+// New returns a KeychainClient logging to the default, rotating log file
+// (see internal/logging) at the "info" level.
+func New() (KeychainClient, error) {
+	path, err := logging.DefaultPath()
+	if err != nil {
+		return KeychainClient{}, err
 	}
 
-	return KeychainClient{
-		logger: logger,
+	logger, _, err := logging.New(path, slog.LevelInfo)
+	if err != nil {
+		return KeychainClient{}, err
 	}
+
+	return WithLogger(logger), nil
 }
 
-func WithLogger(logger *log.Logger) KeychainClient {
+func WithLogger(logger *slog.Logger) KeychainClient {
 	return KeychainClient{
 		logger: logger,
 	}
 }
 
-// passwordFromKeychain retrieves a password given a label from the Keychain
-func passwordFromKeychain(label string) (string, error) {
-	query := keychain.NewItem()
-	query.SetSecClass(keychain.SecClassGenericPassword)
-	query.SetLabel(label)
-	query.SetMatchLimit(keychain.MatchLimitOne)
-	query.SetReturnData(true)
-
-	results, err := keychain.QueryItem(query)
+// passwordFromKeychain retrieves a password given its Keychain account from
+// the Keychain, gated by policy. The item is protected by a
+// SecAccessControl object, so this call blocks on the OS's own Touch ID
+// prompt; there is no separate authentication step.
+func passwordFromKeychain(account string, policy keychain.AccessPolicy) (string, error) {
+	pin, err := keychain.Load(account, policy)
 	if err != nil {
 		return "", err
 	}
 
-	if len(results) > 1 {
-		return "", fmt.Errorf("multiple passwords matched the query")
-	}
-
-	return string(results[0].Data), nil
+	return string(pin), nil
 }
 
-// storePasswordInKeychain saves a password/pin in the keychain with the given label
-// and keyInfo
-func storePasswordInKeychain(label, keyInfo string, pin []byte) error {
-	item := keychain.NewItem()
-	item.SetSecClass(keychain.SecClassGenericPassword)
-	item.SetService("GnuPG")
-	item.SetAccount(keyInfo)
-	item.SetLabel(label)
-	item.SetData(pin)
-	item.SetSynchronizable(keychain.SynchronizableNo)
-	item.SetAccessible(keychain.AccessibleWhenUnlocked)
-
-	if err := keychain.AddItem(item); err != nil {
-		return err
-	}
-
-	return nil
+// storePasswordInKeychain saves a password/pin in the Data Protection
+// Keychain under account, gated by policy. label is stored purely for
+// display in the Keychain app.
+func storePasswordInKeychain(account, label string, pin []byte, policy keychain.AccessPolicy) error {
+	return keychain.Store(account, label, pin, policy)
 }
 
 // askForPassword uses the default pinentry-mac program for getting the password from the user
-func askForPassword(s pinentry.Settings) ([]byte, error) {
+func askForPassword(s assuan.Settings) ([]byte, error) {
 	p, err := pinentryBinary.New()
 	if err != nil {
 		return []byte{}, fmt.Errorf("failed to start %q: %w", pinentryBinary.GetBinary(), err)
@@ -145,141 +122,195 @@ func askForPassword(s pinentry.Settings) ([]byte, error) {
 	return p.GetPin()
 }
 
-type AuthFunc func(reason string) (bool, error)
-type GetPinFunc func(pinentry.Settings) (string, *common.Error)
+type GetPinFunc func(assuan.Settings) (string, *assuan.Error)
 
-func GetPIN(fn AuthFunc, logger *log.Logger) GetPinFunc {
-	return func(s pinentry.Settings) (string, *common.Error) {
+func GetPIN(logger *slog.Logger, accounts *accountindex.Index, cfg *config.Config) GetPinFunc {
+	return func(s assuan.Settings) (string, *assuan.Error) {
 		matches := emailRegex.FindStringSubmatch(s.Desc)
+		if matches == nil {
+			logger.Error("description did not match the expected \"Name <email>\" format", "desc", s.Desc)
+			return "", assuan.ErrGeneral
+		}
 		name := strings.Split(matches[1], " <")[0]
 		email := matches[2]
 
 		matches = keyIDRegex.FindStringSubmatch(s.Desc)
+		if matches == nil {
+			logger.Error("description did not contain a key ID", "desc", s.Desc)
+			return "", assuan.ErrGeneral
+		}
 		keyID := matches[1]
 		if len(keyID) != 8 && len(keyID) != 16 {
-			logger.Fatalf("Invalid keyID: %s", keyID)
+			logger.Error("invalid keyID parsed from description", "keyID", keyID)
+			return "", assuan.ErrGeneral
 		}
 
-		keychainLabel := fmt.Sprintf("%s <%s> (%s)", name, email, keyID)
-		exists, err := checkEntryInKeychain(keychainLabel)
-		if err != nil {
-			logger.Fatalf("error checking entry in keychain: %s", err)
+		// displayLabel is only ever shown in the Keychain app; it is never used to look up the
+		// item, so it does not leak which key a given Keychain entry belongs to.
+		displayLabel := fmt.Sprintf("%s <%s> (%s)", name, email, keyID)
+
+		// s.KeyInfo is usually in the form of x/cacheId, and the cache ID doubles as the key's
+		// keygrip, which is what config.toml's per-key policies are keyed on. It can also be
+		// empty, or lack the "/" entirely, e.g. for a symmetric passphrase prompt with no
+		// SETKEYINFO; in that case there is no keygrip to apply a policy to, so we just fall
+		// back to prompting directly rather than indexing into a too-short split.
+		// https://gist.github.com/mdeguzis/05d1f284f931223624834788da045c65#file-info-pinentry-L357-L362
+		keyInfoParts := strings.SplitN(s.KeyInfo, "/", 2)
+		if len(keyInfoParts) < 2 {
+			logger.Info("no cache ID in KEYINFO; prompting for the PIN directly", "keyInfo", s.KeyInfo)
+			return promptForPassword(s, logger)
 		}
+		cacheID := keyInfoParts[1]
 
-		// If the entry is not found in the keychain, we trigger `pinentry-mac` with the option
-		// to save the pin in the keychain.
-		//
-		// When trying to access the newly created keychain item we will get the normal password prompt
-		// from the OS, we need to "Always allow" access to our application, still the access from our
-		// app to the keychain item will be guarded by Touch ID.
-		//
-		// Currently I'm not aware of a way for automatically adding our binary to the list of always
-		// allowed apps, see: https://github.com/keybase/go-keychain/issues/54.
-		if !exists {
-			pin, err := askForPassword(s)
-			if err != nil {
-				logger.Printf("Error calling pinentry-mac: %s", err)
-			}
-
-			if len(pin) == 0 {
-				logger.Fatalf("pinentry-mac didn't return a password")
-			}
+		if cfg.Denied(cacheID) {
+			logger.Info("keygrip is on the deny-list; always prompting for the PIN", "cacheID", cacheID)
+			return promptForPassword(s, logger)
+		}
 
-			// s.KeyInfo is always in the form of x/cacheId
-			// https://gist.github.com/mdeguzis/05d1f284f931223624834788da045c65#file-info-pinentry-L357-L362
-			keyInfo := strings.Split(s.KeyInfo, "/")[1]
-
-			// pinentry-mac can create an item in the keychain, if that was the case, the user will have
-			// to authorize our app to access the item without asking for a password from the user. If
-			// not, we create an entry in the keychain, which automatically gives us ownership (i.e the
-			// user will not be asked for a password). In either case, the access to the item will be
-			// guarded by Touch ID.
-			exists, err = checkEntryInKeychain(keychainLabel)
-			if err != nil {
-				logger.Fatalf("error checking entry in keychain: %s", err)
-			}
+		policy := cfg.PolicyFor(cacheID)
+		if policy.Method == config.MethodPassword {
+			logger.Info("policy requires a password every time; skipping the Keychain", "cacheID", cacheID)
+			return promptForPassword(s, logger)
+		}
 
-			if !exists {
-				// pinentry-mac didn't create a new entry in the keychain, we create our own and take
-				// ownership over the entry.
-				err = storePasswordInKeychain(keychainLabel, keyInfo, pin)
+		accessPolicy := accessPolicyForKey(policy)
 
-				if err == keychain.ErrorDuplicateItem {
-					logger.Fatalf("Duplicated entry in the keychain")
-				}
-			} else {
-				logger.Printf("The keychain entry was created by pinentry-mac. Permission will be required on next run.")
-			}
+		// account is an unpredictable identifier for cacheID (see internal/accountindex), so
+		// another application cannot sniff the PIN by querying the Keychain for a guessable
+		// label such as the one `displayLabel` used to be stored and matched under.
+		account, err := accounts.Account(cacheID)
+		if err != nil {
+			logger.Error("error resolving keychain account", "label", displayLabel, "error", err)
+			return "", assuan.ErrGeneral
+		}
 
-			return string(pin), nil
+		exists, err := checkEntryInKeychain(account)
+		if err != nil {
+			logger.Error("error checking entry in keychain", "error", err)
+			return "", assuan.ErrGeneral
 		}
 
-		var ok bool
-		if ok, err = fn(fmt.Sprintf("access the PIN for %s", keychainLabel)); err != nil {
-			logger.Fatalf("Error authenticating with Touch ID: %s", err)
+		// If the entry is not found in the keychain, we trigger `pinentry-mac` to prompt for the
+		// PIN, then store it ourselves under account. The item we create is protected by a
+		// SecAccessControl object (see internal/keychain), so there is no "Always Allow" prompt
+		// to click through: the OS enforces Touch ID on every future SecItemCopyMatching call
+		// against this item.
+		if !exists {
+			pin, aerr := promptForPassword(s, logger)
+			if aerr != nil {
+				return pin, aerr
+			}
 
-			return "", nil
-		}
+			if err := storePasswordInKeychain(account, displayLabel, []byte(pin), accessPolicy); err != nil {
+				logger.Error("error storing password in keychain", "error", err)
+				return "", assuan.ErrGeneral
+			}
 
-		if !ok {
-			logger.Printf("Failed to authenticate")
-			return "", nil
+			return pin, nil
 		}
 
-		password, err := passwordFromKeychain(keychainLabel)
+		// Touch ID is enforced by the OS as part of this SecItemCopyMatching call, via the
+		// item's SecAccessControl object; there is no separate authentication step here. A
+		// denied or cancelled evaluation surfaces as an error from passwordFromKeychain, which
+		// we report to gpg-agent as a cancellation rather than killing the pinentry mid-session.
+		password, err := passwordFromKeychain(account, accessPolicy)
 		if err != nil {
-			log.Printf("Error fetching password from Keychain %s", err)
+			logger.Info("error fetching password from keychain", "error", err)
+			return "", assuan.ErrCancelled
 		}
 
 		return password, nil
 	}
 }
 
-func Confirm(pinentry.Settings) (bool, *common.Error) {
-	fmt.Println("Confirm was called!")
+// promptForPassword runs the pinentry-mac fallback UI to ask the user for a PIN directly,
+// without touching the Keychain.
+func promptForPassword(s assuan.Settings, logger *slog.Logger) (string, *assuan.Error) {
+	pin, err := askForPassword(s)
+	if err != nil {
+		logger.Error("error calling pinentry-mac", "error", err)
+	}
+
+	if len(pin) == 0 {
+		logger.Error("pinentry-mac didn't return a password")
+		return "", assuan.ErrCancelled
+	}
+
+	return string(pin), nil
+}
 
-	return true, nil
+// Confirm answers a CONFIRM request. os.Stdout is the Assuan channel itself,
+// so unlike GetPIN's pinentry-mac fallback, this must never write anything
+// to it directly; any logging goes to logger instead. There is no
+// confirmation UI yet, so every request is accepted.
+func Confirm(logger *slog.Logger) func(assuan.Settings) (bool, *assuan.Error) {
+	return func(s assuan.Settings) (bool, *assuan.Error) {
+		logger.Info("CONFIRM requested", "desc", s.Desc)
+		return true, nil
+	}
 }
 
-func Msg(pinentry.Settings) *common.Error {
-	fmt.Println("Msg was called!")
+// Msg answers a MESSAGE request, which only asks us to display s.Desc; we
+// have no UI for it yet, so we just acknowledge it.
+func Msg(logger *slog.Logger) func(assuan.Settings) *assuan.Error {
+	return func(s assuan.Settings) *assuan.Error {
+		logger.Info("MESSAGE requested", "desc", s.Desc)
+		return nil
+	}
+}
 
-	return nil
+// fatal reports a startup error that leaves the process unable to run at
+// all, and exits. Unlike the errors handled inside GetPIN, there is no
+// gpg-agent session yet to report an Assuan error to.
+func fatal(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
 }
 
 func main() {
-	var logger *log.Logger
-	if _, err := os.Stat(DefaultLogLocation); os.IsNotExist(err) {
-		file, err1 := os.Create(DefaultLogLocation)
-		if err1 != nil {
-			panic(err1)
-		}
-		// new file if it doesn't exist
-		logger = log.New(file, "", log.Ldate|log.Ltime|log.Lshortfile)
-	} else {
-		// append to the existing log file
-		file, err := os.OpenFile(DefaultLogLocation, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
-		if err != nil {
-			panic(err)
-		}
-		logger = log.New(file, "", log.Ldate|log.Ltime|log.Lshortfile)
+	defaultLogPath, err := logging.DefaultPath()
+	if err != nil {
+		fatal("error determining default log path: %s", err)
+	}
+
+	logFile := flag.String("log-file", defaultLogPath, "path to the log file")
+	defaultLogLevel := "info"
+	if v := os.Getenv("PINENTRY_TOUCHID_LOG_LEVEL"); v != "" {
+		defaultLogLevel = v
+	}
+	logLevel := flag.String("log-level", defaultLogLevel, "log level: debug, info, warn, or error")
+	flag.Parse()
+
+	logger, closeLog, err := logging.New(*logFile, logging.ParseLevel(*logLevel))
+	if err != nil {
+		fatal("error opening log file %q: %s", *logFile, err)
 	}
+	defer closeLog()
 
-	logger.Println("Ready!")
+	logger.Info("Ready!")
 
 	if _, err := exec.LookPath(pinentryBinary.GetBinary()); err != nil {
-		log.Fatalf("PIN entry program %q not found!", pinentryBinary.GetBinary())
+		fatal("PIN entry program %q not found!", pinentryBinary.GetBinary())
 	}
 
-	callbacks := pinentry.Callbacks{
-		GetPIN: func(s pinentry.Settings) (string, *common.Error) {
-			return GetPIN(func(reason string) (bool, error) {
-				return touchid.Authenticate(reason)
-			}, logger)(s)
-		},
-		Confirm: Confirm,
-		Msg:     Msg,
+	accounts, err := accountindex.Open()
+	if err != nil {
+		fatal("error opening account index: %s", err)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("error loading config: %s", err)
 	}
 
-	pinentry.Serve(callbacks, "Hi from pinentry-mac-touchid!")
-}
\ No newline at end of file
+	callbacks := assuan.Callbacks{
+		GetPIN:  GetPIN(logger, accounts, cfg),
+		Confirm: Confirm(logger),
+		Msg:     Msg(logger),
+	}
+
+	if err := assuan.NewServer(os.Stdin, os.Stdout, callbacks).Serve("Hi from pinentry-mac-touchid!"); err != nil {
+		logger.Error("Assuan server exited", "error", err)
+		os.Exit(1)
+	}
+}